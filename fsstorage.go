@@ -0,0 +1,240 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FSStorageOptions bounds the size of an FSStorage and configures its
+// background eviction. The zero value disables eviction entirely.
+type FSStorageOptions struct {
+	// MaxBytes, if positive, is the total entry size the store evicts down
+	// to whenever it runs eviction.
+	MaxBytes int64
+	// MaxEntries, if positive, is the entry count the store evicts down to.
+	MaxEntries int
+	// EvictInterval is how often the background evictor runs. Defaults to
+	// one minute when MaxBytes or MaxEntries is set and this is zero.
+	EvictInterval time.Duration
+}
+
+// FSStorage is the default Storage backend: one file per key under a base
+// directory, written atomically via a temp file and rename. Many
+// filesystems mount with atime updates disabled, so last-access time for
+// eviction is tracked in a small sidecar file instead of relying on the
+// file's atime.
+type FSStorage struct {
+	baseDir string
+	opts    FSStorageOptions
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewFSStorage creates a filesystem-backed Storage rooted at baseDir with
+// no size-bounded eviction.
+func NewFSStorage(baseDir string) (*FSStorage, error) {
+	return NewFSStorageWithOptions(baseDir, FSStorageOptions{})
+}
+
+// NewFSStorageWithOptions creates a filesystem-backed Storage rooted at
+// baseDir. When opts bounds the store's size or entry count, a background
+// goroutine periodically evicts the least-recently-accessed entries to
+// stay within budget; call Close to stop it.
+func NewFSStorageWithOptions(baseDir string, opts FSStorageOptions) (*FSStorage, error) {
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+
+	s := &FSStorage{baseDir: baseDir, opts: opts}
+	if opts.MaxBytes > 0 || opts.MaxEntries > 0 {
+		interval := opts.EvictInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.evictLoop(interval)
+	}
+	return s, nil
+}
+
+// Close stops the background evictor, if eviction is enabled.
+func (s *FSStorage) Close() error {
+	if s.stop == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *FSStorage) path(key string) string {
+	return filepath.Join(s.baseDir, filepath.FromSlash(key))
+}
+
+func (s *FSStorage) atimePath(key string) string {
+	return s.path(key) + ".atime"
+}
+
+func (s *FSStorage) Get(key string) (Entry, error) {
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Entry{}, ErrNotFound
+		}
+		return Entry{}, err
+	}
+
+	// Put sets the file's mtime to the entry's StoredAt; the atime sidecar
+	// records last access, not when the entry was written.
+	var storedAt time.Time
+	if info, statErr := os.Stat(path); statErr == nil {
+		storedAt = info.ModTime()
+	}
+	s.touch(key)
+	return Entry{Data: data, StoredAt: storedAt}, nil
+}
+
+func (s *FSStorage) Put(key string, e Entry) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, e.Data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	storedAt := e.StoredAt
+	if storedAt.IsZero() {
+		storedAt = time.Now()
+	}
+	_ = os.Chtimes(path, storedAt, storedAt)
+	s.touchAt(key, storedAt)
+	return nil
+}
+
+func (s *FSStorage) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_ = os.Remove(s.atimePath(key))
+	return nil
+}
+
+func (s *FSStorage) Keys() ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if name := d.Name(); strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".atime") {
+			return nil
+		}
+		rel, relErr := filepath.Rel(s.baseDir, path)
+		if relErr != nil {
+			return relErr
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *FSStorage) touch(key string) {
+	s.touchAt(key, time.Now())
+}
+
+func (s *FSStorage) touchAt(key string, t time.Time) {
+	_ = os.WriteFile(s.atimePath(key), []byte(strconv.FormatInt(t.UnixNano(), 10)), 0o644)
+}
+
+func (s *FSStorage) readAccessTime(key string) time.Time {
+	data, err := os.ReadFile(s.atimePath(key))
+	if err != nil {
+		return time.Time{}
+	}
+	nanos, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (s *FSStorage) evictLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.evict()
+		}
+	}
+}
+
+type fsEvictCandidate struct {
+	key      string
+	size     int64
+	accessed time.Time
+}
+
+// evict deletes the least-recently-accessed entries until the store is
+// back within MaxBytes and MaxEntries.
+func (s *FSStorage) evict() {
+	keys, err := s.Keys()
+	if err != nil {
+		return
+	}
+
+	candidates := make([]fsEvictCandidate, 0, len(keys))
+	var totalBytes int64
+	for _, key := range keys {
+		if isVariantIndexKey(key) {
+			continue
+		}
+		info, statErr := os.Stat(s.path(key))
+		if statErr != nil {
+			continue
+		}
+		accessed := s.readAccessTime(key)
+		if accessed.IsZero() {
+			accessed = info.ModTime()
+		}
+		candidates = append(candidates, fsEvictCandidate{key: key, size: info.Size(), accessed: accessed})
+		totalBytes += info.Size()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].accessed.Before(candidates[j].accessed) })
+
+	i := 0
+	overBytes := s.opts.MaxBytes > 0 && totalBytes > s.opts.MaxBytes
+	overCount := s.opts.MaxEntries > 0 && len(candidates)-i > s.opts.MaxEntries
+	for (overBytes || overCount) && i < len(candidates) {
+		_ = s.Delete(candidates[i].key)
+		totalBytes -= candidates[i].size
+		i++
+		overBytes = s.opts.MaxBytes > 0 && totalBytes > s.opts.MaxBytes
+		overCount = s.opts.MaxEntries > 0 && len(candidates)-i > s.opts.MaxEntries
+	}
+}
@@ -0,0 +1,42 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// gzipMagic is the two-byte header every gzip stream starts with.
+var gzipMagic = [2]byte{0x1f, 0x8b}
+
+// looksGzipped reports whether body begins with the gzip magic bytes,
+// without requiring any separate on-disk format flag.
+func looksGzipped(body []byte) bool {
+	return len(body) >= 2 && body[0] == gzipMagic[0] && body[1] == gzipMagic[1]
+}
+
+// compressBody gzips body for storage. Callers should skip compressing a
+// body that is already gzip-encoded (an upstream response stored verbatim
+// under Content-Encoding: gzip) to avoid a wasted decompress/recompress
+// cycle.
+func compressBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressBody reverses compressBody.
+func decompressBody(body []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	return io.ReadAll(gr)
+}
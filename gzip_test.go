@@ -0,0 +1,158 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCompressBodyRoundTrip(t *testing.T) {
+	original := []byte("hello, compressed world")
+
+	compressed, err := compressBody(original)
+	if err != nil {
+		t.Fatalf("compressBody() error = %v", err)
+	}
+	if !looksGzipped(compressed) {
+		t.Fatal("expected compressed body to start with the gzip magic bytes")
+	}
+
+	plain, err := decompressBody(compressed)
+	if err != nil {
+		t.Fatalf("decompressBody() error = %v", err)
+	}
+	if string(plain) != string(original) {
+		t.Fatalf("expected round-tripped body %q, got %q", original, plain)
+	}
+}
+
+func TestWriteCacheFileCompressesAndReadCacheFileDecompresses(t *testing.T) {
+	storage := NewLRUStorage(0)
+	rec := &cacheRecord{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: []byte("plain text body")}
+
+	if err := writeCacheFile(storage, "key", rec, true); err != nil {
+		t.Fatalf("writeCacheFile() error = %v", err)
+	}
+
+	entry, err := storage.Get("key")
+	if err != nil {
+		t.Fatalf("storage.Get() error = %v", err)
+	}
+	stored, err := decodeRecord(entry.Data)
+	if err != nil {
+		t.Fatalf("decodeRecord() error = %v", err)
+	}
+	if !looksGzipped(stored.Body) {
+		t.Fatal("expected body to be stored gzip-compressed")
+	}
+
+	got, _, err := readCacheFile(storage, "key")
+	if err != nil {
+		t.Fatalf("readCacheFile() error = %v", err)
+	}
+	if string(got.Body) != "plain text body" {
+		t.Fatalf("expected decompressed body, got %q", got.Body)
+	}
+}
+
+func TestWriteCacheFileLeavesUpstreamGzipVerbatim(t *testing.T) {
+	storage := NewLRUStorage(0)
+	upstreamGzip, err := compressBody([]byte("already gzipped by upstream"))
+	if err != nil {
+		t.Fatalf("compressBody() error = %v", err)
+	}
+
+	rec := &cacheRecord{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Encoding": []string{"gzip"}},
+		Body:       upstreamGzip,
+	}
+
+	if err := writeCacheFile(storage, "key", rec, true); err != nil {
+		t.Fatalf("writeCacheFile() error = %v", err)
+	}
+
+	entry, err := storage.Get("key")
+	if err != nil {
+		t.Fatalf("storage.Get() error = %v", err)
+	}
+	stored, err := decodeRecord(entry.Data)
+	if err != nil {
+		t.Fatalf("decodeRecord() error = %v", err)
+	}
+	if string(stored.Body) != string(upstreamGzip) {
+		t.Fatal("expected upstream gzip body to be stored verbatim, not recompressed")
+	}
+
+	got, _, err := readCacheFile(storage, "key")
+	if err != nil {
+		t.Fatalf("readCacheFile() error = %v", err)
+	}
+	if string(got.Body) != string(upstreamGzip) {
+		t.Fatal("expected upstream gzip body to be returned verbatim with Content-Encoding preserved")
+	}
+	if got.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatal("expected Content-Encoding: gzip header to be preserved")
+	}
+}
+
+func TestRoundTripWithCompressBodiesOption(t *testing.T) {
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("fresh body for compression")),
+				Header:     http.Header{},
+				Request:    req,
+			}, nil
+		},
+	}
+
+	storage := NewLRUStorage(0)
+	rawRT, err := NewRoundTripperWithStorageAndOptions(storage, mockRT, RoundTripperOptions{CompressBodies: true})
+	if err != nil {
+		t.Fatalf("NewRoundTripperWithStorageAndOptions() error = %v", err)
+	}
+	rt := rawRT.(*cacheRoundTripper)
+
+	req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/compressed", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, resp.Body); got != "fresh body for compression" {
+		t.Fatalf("expected decompressed body on first response, got %q", got)
+	}
+
+	downstreamURL, err := url.Parse("https://example.com/compressed")
+	if err != nil {
+		t.Fatalf("parse downstream URL: %v", err)
+	}
+	key := defaultCacheKey(rt, downstreamURL)
+	entry, err := storage.Get(key)
+	if err != nil {
+		t.Fatalf("storage.Get() error = %v", err)
+	}
+	stored, err := decodeRecord(entry.Data)
+	if err != nil {
+		t.Fatalf("decodeRecord() error = %v", err)
+	}
+	if !looksGzipped(stored.Body) {
+		t.Fatal("expected body to be stored gzip-compressed on disk")
+	}
+
+	mockRT.roundTripFunc = func(req *http.Request) (*http.Response, error) {
+		return nil, errUpstreamUnavailable
+	}
+	resp2, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "cache:https://example.com/compressed", nil))
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, resp2.Body); got != "fresh body for compression" {
+		t.Fatalf("expected decompressed cached body on fallback, got %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+package httpcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRUStorage is an in-memory Storage backend useful for tests and
+// short-lived processes: it never touches disk and evicts the
+// least-recently-used entries once maxBytes of entry data is exceeded.
+type LRUStorage struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	usedBytes int64
+	order     *list.List
+	items     map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry Entry
+}
+
+// NewLRUStorage creates an in-memory Storage bounded to maxBytes of entry
+// data. maxBytes <= 0 means unbounded.
+func NewLRUStorage(maxBytes int64) *LRUStorage {
+	return &LRUStorage{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (s *LRUStorage) Get(key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, nil
+}
+
+func (s *LRUStorage) Put(key string, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.StoredAt.IsZero() {
+		e.StoredAt = time.Now()
+	}
+
+	pinned := isVariantIndexKey(key)
+	if el, ok := s.items[key]; ok {
+		if !pinned {
+			s.usedBytes -= int64(len(el.Value.(*lruItem).entry.Data))
+		}
+		el.Value.(*lruItem).entry = e
+		s.order.MoveToFront(el)
+	} else {
+		s.items[key] = s.order.PushFront(&lruItem{key: key, entry: e})
+	}
+	if !pinned {
+		s.usedBytes += int64(len(e.Data))
+	}
+
+	s.evictLocked()
+	return nil
+}
+
+func (s *LRUStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+	return nil
+}
+
+func (s *LRUStorage) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *LRUStorage) removeLocked(key string) {
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	if !isVariantIndexKey(key) {
+		s.usedBytes -= int64(len(el.Value.(*lruItem).entry.Data))
+	}
+	s.order.Remove(el)
+	delete(s.items, key)
+}
+
+// evictLocked removes the least-recently-used entries until usedBytes is
+// back within maxBytes. Variant index entries are pinned (see
+// isVariantIndexKey): they don't count against maxBytes and are skipped
+// when choosing a victim, since evicting one independently of the variant
+// entries it describes would desynchronize the two.
+func (s *LRUStorage) evictLocked() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	for el := s.order.Back(); s.usedBytes > s.maxBytes && el != nil; {
+		prev := el.Prev()
+		key := el.Value.(*lruItem).key
+		if !isVariantIndexKey(key) {
+			s.removeLocked(key)
+		}
+		el = prev
+	}
+}
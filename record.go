@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheRecord is the on-disk representation of a cached response: enough of
+// the original response to reconstruct it faithfully, instead of the
+// synthetic 200 OK the cache used to fabricate.
+type cacheRecord struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+
+	// Stale marks a record whose response asked for revalidation before
+	// reuse, e.g. because the upstream sent Cache-Control: private,
+	// max-age=0 or must-revalidate on it. A stale record served from cache
+	// (cachez://, or a cache:// fallback when revalidation fails) carries a
+	// Warning: 110 header.
+	Stale bool
+}
+
+func encodeRecord(rec *cacheRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(data []byte) (*cacheRecord, error) {
+	var rec cacheRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// cacheControlDirectives is the subset of Cache-Control request/response
+// directives this package acts on.
+type cacheControlDirectives struct {
+	noStore        bool
+	private        bool
+	mustRevalidate bool
+	maxAge         int
+	hasMaxAge      bool
+}
+
+func parseCacheControl(h http.Header) cacheControlDirectives {
+	var d cacheControlDirectives
+	for _, header := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(header, ",") {
+			directive := strings.TrimSpace(part)
+			name, value, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+
+			switch name {
+			case "no-store":
+				d.noStore = true
+			case "private":
+				d.private = true
+			case "must-revalidate":
+				d.mustRevalidate = true
+			case "max-age":
+				if seconds, err := strconv.Atoi(value); err == nil {
+					d.maxAge = seconds
+					d.hasMaxAge = true
+				}
+			}
+		}
+	}
+	return d
+}
+
+// mergeRevalidationHeaders updates rec.Header with the freshness-related
+// headers from a 304 Not Modified response, per RFC 7234 §4.3.4.
+func mergeRevalidationHeaders(rec *cacheRecord, fresh http.Header) {
+	if rec.Header == nil {
+		rec.Header = http.Header{}
+	}
+	for _, name := range []string{"Cache-Control", "Expires", "Date"} {
+		if value := fresh.Get(name); value != "" {
+			rec.Header.Set(name, value)
+		}
+	}
+}
+
+// ageSeconds computes the age of rec as the whole seconds elapsed since its
+// stored Date header, falling back to 0 when Date is absent, unparsable or
+// in the future.
+func ageSeconds(rec *cacheRecord) int {
+	dateHeader := rec.Header.Get("Date")
+	if dateHeader == "" {
+		return 0
+	}
+	date, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return 0
+	}
+	age := int(time.Since(date).Seconds())
+	if age < 0 {
+		return 0
+	}
+	return age
+}
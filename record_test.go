@@ -0,0 +1,130 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTripSendsIfNoneMatchFromETag(t *testing.T) {
+	cacheDir := t.TempDir()
+	mockRT := &mockRoundTripper{}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+	rt := rawRT.(*cacheRoundTripper)
+
+	downstreamURL, err := url.Parse("https://example.com/data")
+	if err != nil {
+		t.Fatalf("parse downstream URL: %v", err)
+	}
+	rec := &cacheRecord{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Etag": []string{`"v1"`}},
+		Body:       []byte("cached-value"),
+	}
+	if err := writeCacheFile(rt.storage, defaultCacheKey(rt, downstreamURL), rec, false); err != nil {
+		t.Fatalf("write cache file: %v", err)
+	}
+
+	mockRT.roundTripFunc = func(req *http.Request) (*http.Response, error) {
+		if got := req.Header.Get("If-None-Match"); got != `"v1"` {
+			t.Fatalf("expected If-None-Match %q, got %q", `"v1"`, got)
+		}
+		return &http.Response{
+			StatusCode: http.StatusNotModified,
+			Body:       http.NoBody,
+			Header:     http.Header{"Date": []string{time.Now().UTC().Format(http.TimeFormat)}},
+			Request:    req,
+		}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if body := readBody(t, resp.Body); body != "cached-value" {
+		t.Fatalf("expected cached body, got %q", body)
+	}
+	if age := resp.Header.Get("Age"); age == "" {
+		t.Fatal("expected Age header to be set")
+	}
+}
+
+func TestRoundTripHonorsNoStore(t *testing.T) {
+	cacheDir := t.TempDir()
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Cache-Control": []string{"no-store"}},
+				Body:       io.NopCloser(strings.NewReader("secret")),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+	rt := rawRT.(*cacheRoundTripper)
+
+	req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/secret", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+
+	downstreamURL, err := url.Parse("https://example.com/secret")
+	if err != nil {
+		t.Fatalf("parse downstream URL: %v", err)
+	}
+	if _, _, err := readCacheFile(rt.storage, defaultCacheKey(rt, downstreamURL)); err == nil {
+		t.Fatal("expected no cache file to be written for no-store response")
+	}
+}
+
+func TestRoundTripMarksPrivateResponsesStale(t *testing.T) {
+	cacheDir := t.TempDir()
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Cache-Control": []string{"private"}},
+				Body:       io.NopCloser(strings.NewReader("mine")),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+	rt := rawRT.(*cacheRoundTripper)
+
+	req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/mine", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+
+	downstreamURL, err := url.Parse("https://example.com/mine")
+	if err != nil {
+		t.Fatalf("parse downstream URL: %v", err)
+	}
+	rec, _, err := readCacheFile(rt.storage, defaultCacheKey(rt, downstreamURL))
+	if err != nil {
+		t.Fatalf("read cache file: %v", err)
+	}
+	if !rec.Stale {
+		t.Fatal("expected record to be marked stale")
+	}
+}
@@ -9,31 +9,65 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type cacheRoundTripper struct {
-	original http.RoundTripper
-	cacheDir string
+	original       http.RoundTripper
+	storage        Storage
+	flights        flightGroup
+	variantLocks   urlIndexLocks
+	compressBodies bool
 }
 
-// NewRoundTripper creates a chainable RoundTripper that handles cache:// and cachez:// schemes.
+// RoundTripperOptions configures optional behavior of a cacheRoundTripper
+// beyond the defaults used by NewRoundTripper and NewRoundTripperWithStorage.
+type RoundTripperOptions struct {
+	// CompressBodies gzips response bodies before writing them to Storage.
+	// A body that is already gzip-encoded (an upstream response with
+	// Content-Encoding: gzip, stored verbatim) is left untouched rather
+	// than decompressed and recompressed. Reading is always
+	// compression-aware regardless of this setting, so entries written
+	// with and without it can be mixed freely.
+	CompressBodies bool
+}
+
+// NewRoundTripper creates a chainable RoundTripper that handles cache:// and
+// cachez:// schemes, persisting cache entries as files under cacheDir.
 func NewRoundTripper(cacheDir string, original http.RoundTripper) (http.RoundTripper, error) {
 	if cacheDir == "" {
 		return nil, fmt.Errorf("cache directory is required")
 	}
-	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-		return nil, fmt.Errorf("create cache directory: %w", err)
+	storage, err := NewFSStorage(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewRoundTripperWithStorage(storage, original)
+}
+
+// NewRoundTripperWithStorage creates a chainable RoundTripper backed by an
+// arbitrary Storage implementation, so callers can swap in e.g. an
+// in-memory store without changing cache://"/"cachez:// semantics.
+func NewRoundTripperWithStorage(storage Storage, original http.RoundTripper) (http.RoundTripper, error) {
+	return NewRoundTripperWithStorageAndOptions(storage, original, RoundTripperOptions{})
+}
+
+// NewRoundTripperWithStorageAndOptions is NewRoundTripperWithStorage with
+// additional, optional behavior controlled by opts.
+func NewRoundTripperWithStorageAndOptions(storage Storage, original http.RoundTripper, opts RoundTripperOptions) (http.RoundTripper, error) {
+	if storage == nil {
+		return nil, fmt.Errorf("storage is required")
 	}
 	if original == nil {
 		original = http.DefaultTransport
 	}
 
 	return &cacheRoundTripper{
-		original: original,
-		cacheDir: cacheDir,
+		original:       original,
+		storage:        storage,
+		compressBodies: opts.CompressBodies,
 	}, nil
 }
 
@@ -74,31 +108,57 @@ func (c *cacheRoundTripper) roundTripWithValidation(req *http.Request) (*http.Re
 		return nil, err
 	}
 
-	cachePath := c.cachePathForURL(downstreamURL)
-	cachedBody, cachedInfo, cacheErr := readCacheFile(cachePath)
-	hasCache := cacheErr == nil
-	if cacheErr != nil && !errors.Is(cacheErr, os.ErrNotExist) {
-		return nil, cacheErr
+	urlKey := c.urlKey(downstreamURL)
+	key := urlKey + "#" + flightSelectorHash(c.storage, urlKey, req.Header)
+
+	result := c.flights.do(key, func() flightResult {
+		return c.fetchAndCache(req, downstreamURL, urlKey)
+	})
+	if result.err != nil {
+		return nil, result.err
+	}
+	return buildResponse(req, result), nil
+}
+
+// fetchAndCache performs the actual upstream revalidation and cache write
+// for a cache:// request. It is the unit of work coalesced by flightGroup,
+// so its result must be safe to hand to every goroutine waiting on the
+// same key rather than just the caller that triggered it.
+func (c *cacheRoundTripper) fetchAndCache(req *http.Request, downstreamURL *url.URL, urlKey string) flightResult {
+	cacheKey, hasVariant := lookupVariant(c.storage, urlKey, req.Header)
+
+	var cachedRecord *cacheRecord
+	var cachedAt time.Time
+	hasCache := false
+	if hasVariant {
+		var cacheErr error
+		cachedRecord, cachedAt, cacheErr = readCacheFile(c.storage, cacheKey)
+		hasCache = cacheErr == nil
+		if cacheErr != nil && !errors.Is(cacheErr, ErrNotFound) {
+			return flightResult{err: cacheErr}
+		}
 	}
 
 	upstreamReq := req.Clone(req.Context())
 	upstreamReq.URL = downstreamURL
 
-	if hasCache && upstreamReq.Header.Get("If-Modified-Since") == "" {
-		upstreamReq.Header.Set("If-Modified-Since", cachedInfo.ModTime().UTC().Format(http.TimeFormat))
+	if hasCache {
+		setRevalidationHeaders(upstreamReq, cachedRecord, cachedAt)
 	}
 
 	resp, err := c.original.RoundTrip(upstreamReq)
 	if err != nil {
 		if hasCache {
-			return cachedResponse(req, cachedBody), nil
+			return recordToResult(cachedRecord)
 		}
-		return nil, err
+		return flightResult{err: err}
 	}
 
 	if hasCache && resp.StatusCode == http.StatusNotModified {
 		drainAndClose(resp.Body)
-		return cachedResponse(req, cachedBody), nil
+		mergeRevalidationHeaders(cachedRecord, resp.Header)
+		_ = writeCacheFile(c.storage, cacheKey, cachedRecord, c.compressBodies)
+		return recordToResult(cachedRecord)
 	}
 
 	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
@@ -106,24 +166,60 @@ func (c *cacheRoundTripper) roundTripWithValidation(req *http.Request) (*http.Re
 		drainAndClose(resp.Body)
 		if readErr != nil {
 			if hasCache {
-				return cachedResponse(req, cachedBody), nil
+				return recordToResult(cachedRecord)
 			}
-			return nil, readErr
+			return flightResult{err: readErr}
 		}
 
-		_ = writeCacheFile(cachePath, body)
+		status, header := responseMetadata(resp)
+		rec := &cacheRecord{
+			StatusCode: resp.StatusCode,
+			Status:     status,
+			Header:     header,
+			Body:       body,
+		}
+
+		directives := parseCacheControl(resp.Header)
+		if directives.noStore {
+			return flightResult{statusCode: resp.StatusCode, status: status, header: header, body: body}
+		}
+		if directives.private || directives.mustRevalidate || (directives.hasMaxAge && directives.maxAge == 0) {
+			rec.Stale = true
+		}
 
-		resp.Body = io.NopCloser(bytes.NewReader(body))
-		resp.ContentLength = int64(len(body))
-		return resp, nil
+		unlock := c.variantLocks.lock(urlKey)
+		variantKey, idx, storeErr := storeVariant(c.storage, urlKey, upstreamReq.Header, resp.Header)
+		if storeErr == nil {
+			if err := writeCacheFile(c.storage, variantKey, rec, c.compressBodies); err == nil {
+				_ = saveVariantIndex(c.storage, urlKey, idx)
+			}
+		}
+		unlock()
+
+		return flightResult{statusCode: resp.StatusCode, status: status, header: header, body: body}
 	}
 
 	if hasCache {
 		drainAndClose(resp.Body)
-		return cachedResponse(req, cachedBody), nil
+		return recordToResult(cachedRecord)
 	}
 
-	return resp, nil
+	body, _ := io.ReadAll(resp.Body)
+	drainAndClose(resp.Body)
+	status, header := responseMetadata(resp)
+	return flightResult{statusCode: resp.StatusCode, status: status, header: header, body: body}
+}
+
+func responseMetadata(resp *http.Response) (status string, header http.Header) {
+	status = resp.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	header = resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return status, header
 }
 
 func (c *cacheRoundTripper) roundTripCacheOnly(req *http.Request) (*http.Response, error) {
@@ -132,54 +228,142 @@ func (c *cacheRoundTripper) roundTripCacheOnly(req *http.Request) (*http.Respons
 		return nil, err
 	}
 
-	cachePath := c.cachePathForURL(downstreamURL)
-	cachedBody, _, err := readCacheFile(cachePath)
+	urlKey := c.urlKey(downstreamURL)
+	cacheKey, hasVariant := lookupVariant(c.storage, urlKey, req.Header)
+	if !hasVariant {
+		return nil, fmt.Errorf("cache miss for %s", downstreamURL.String())
+	}
+
+	cachedRecord, _, err := readCacheFile(c.storage, cacheKey)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
+		if errors.Is(err, ErrNotFound) {
 			return nil, fmt.Errorf("cache miss for %s", downstreamURL.String())
 		}
 		return nil, err
 	}
 
-	return cachedResponse(req, cachedBody), nil
+	return cachedResponse(req, cachedRecord), nil
 }
 
-func (c *cacheRoundTripper) cachePathForURL(u *url.URL) string {
+// setRevalidationHeaders adds conditional-request headers derived from a
+// previously cached record, preferring the validators the upstream sent
+// (ETag, Last-Modified) and falling back to the record's stored time only
+// when neither is present.
+func setRevalidationHeaders(upstreamReq *http.Request, rec *cacheRecord, storedAt time.Time) {
+	etag := rec.Header.Get("ETag")
+	lastModified := rec.Header.Get("Last-Modified")
+
+	if etag != "" && upstreamReq.Header.Get("If-None-Match") == "" {
+		upstreamReq.Header.Set("If-None-Match", etag)
+	}
+
+	switch {
+	case lastModified != "":
+		if upstreamReq.Header.Get("If-Modified-Since") == "" {
+			upstreamReq.Header.Set("If-Modified-Since", lastModified)
+		}
+	case etag == "":
+		if upstreamReq.Header.Get("If-Modified-Since") == "" {
+			upstreamReq.Header.Set("If-Modified-Since", storedAt.UTC().Format(http.TimeFormat))
+		}
+	}
+}
+
+// urlKey derives the opaque Storage key identifying downstreamURL.
+func (c *cacheRoundTripper) urlKey(u *url.URL) string {
 	keyURL := cloneURL(u)
 	if keyURL.Scheme == "cache" || keyURL.Scheme == "cachez" {
 		keyURL.Scheme = "http"
 	}
 	hash := sha256.Sum256([]byte(keyURL.String()))
-	return filepath.Join(c.cacheDir, hex.EncodeToString(hash[:]))
+	return hex.EncodeToString(hash[:])
 }
 
-func readCacheFile(path string) ([]byte, os.FileInfo, error) {
-	info, err := os.Stat(path)
+// readCacheFile loads the record stored at key. A body gzip-compressed by
+// writeCacheFile is transparently decompressed so callers always see the
+// original bytes; a body stored verbatim under an upstream
+// Content-Encoding: gzip (which also happens to start with the gzip magic
+// bytes) is left alone so that header and body stay consistent.
+func readCacheFile(storage Storage, key string) (*cacheRecord, time.Time, error) {
+	entry, err := storage.Get(key)
 	if err != nil {
-		return nil, nil, err
+		return nil, time.Time{}, err
 	}
-	data, err := os.ReadFile(path)
+	rec, err := decodeRecord(entry.Data)
 	if err != nil {
-		return nil, nil, err
+		return nil, time.Time{}, err
+	}
+	if looksGzipped(rec.Body) && rec.Header.Get("Content-Encoding") != "gzip" {
+		if plain, decErr := decompressBody(rec.Body); decErr == nil {
+			rec.Body = plain
+		}
 	}
-	return data, info, nil
+	return rec, entry.StoredAt, nil
 }
 
-func writeCacheFile(path string, data []byte) error {
-	tmpPath := path + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+// writeCacheFile persists rec at key. When compress is set, the body is
+// gzipped before storage unless rec carries Content-Encoding: gzip (an
+// upstream gzip response kept verbatim), avoiding an unnecessary
+// decompress/recompress cycle. A body without that header which itself
+// starts with the gzip magic bytes is gzipped even when compress is off, so
+// that readCacheFile's sniffing returns it unchanged. rec itself is left
+// unmodified.
+func writeCacheFile(storage Storage, key string, rec *cacheRecord, compress bool) error {
+	body := rec.Body
+	if rec.Header.Get("Content-Encoding") != "gzip" && (compress || looksGzipped(body)) {
+		if compressed, err := compressBody(body); err == nil {
+			body = compressed
+		}
+	}
+
+	toStore := *rec
+	toStore.Body = body
+	data, err := encodeRecord(&toStore)
+	if err != nil {
 		return err
 	}
-	return os.Rename(tmpPath, path)
+	return storage.Put(key, Entry{Data: data})
 }
 
-func cachedResponse(req *http.Request, body []byte) *http.Response {
+// recordToResult turns a stored cache record into a flightResult, adding
+// the headers that mark a response as served from cache.
+func recordToResult(rec *cacheRecord) flightResult {
+	header := rec.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("X-HTTP-Cache", "HIT")
+	header.Set("Age", strconv.Itoa(ageSeconds(rec)))
+	if rec.Stale {
+		header.Set("Warning", `110 - "Response is Stale"`)
+	}
+
+	return flightResult{
+		statusCode: rec.StatusCode,
+		status:     rec.Status,
+		header:     header,
+		body:       rec.Body,
+	}
+}
+
+func cachedResponse(req *http.Request, rec *cacheRecord) *http.Response {
+	return buildResponse(req, recordToResult(rec))
+}
+
+// buildResponse builds an independent *http.Response for req from a
+// flightResult, so multiple callers sharing one coalesced fetch each get
+// their own Body reader and header map.
+func buildResponse(req *http.Request, result flightResult) *http.Response {
+	header := result.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
 	return &http.Response{
-		StatusCode:    http.StatusOK,
-		Status:        "200 OK",
-		Body:          io.NopCloser(bytes.NewReader(body)),
-		Header:        http.Header{"X-HTTP-Cache": []string{"HIT"}},
-		ContentLength: int64(len(body)),
+		StatusCode:    result.statusCode,
+		Status:        result.status,
+		Body:          io.NopCloser(bytes.NewReader(result.body)),
+		Header:        header,
+		ContentLength: int64(len(result.body)),
 		Request:       req,
 	}
 }
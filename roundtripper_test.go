@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync/atomic"
@@ -14,13 +13,18 @@ import (
 	"time"
 )
 
+// defaultCacheKey returns the Storage key for u's no-Vary cache entry.
+func defaultCacheKey(rt *cacheRoundTripper, u *url.URL) string {
+	return variantEntryKey(rt.urlKey(u), defaultVariantFile)
+}
+
 type mockRoundTripper struct {
-	callCount     int
+	callCount     atomic.Int32
 	roundTripFunc func(req *http.Request) (*http.Response, error)
 }
 
 func (m *mockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	m.callCount++
+	m.callCount.Add(1)
 	if m.roundTripFunc != nil {
 		return m.roundTripFunc(req)
 	}
@@ -85,13 +89,12 @@ func TestRoundTripCacheFetchAndStore(t *testing.T) {
 	if err != nil {
 		t.Fatalf("parse downstream URL: %v", err)
 	}
-	cachePath := rt.cachePathForURL(downstreamURL)
-	cachedBytes, err := os.ReadFile(cachePath)
+	cachedRecord, _, err := readCacheFile(rt.storage, defaultCacheKey(rt, downstreamURL))
 	if err != nil {
 		t.Fatalf("read cache file: %v", err)
 	}
-	if string(cachedBytes) != "fresh" {
-		t.Fatalf("expected cached body fresh, got %q", string(cachedBytes))
+	if string(cachedRecord.Body) != "fresh" {
+		t.Fatalf("expected cached body fresh, got %q", string(cachedRecord.Body))
 	}
 }
 
@@ -110,14 +113,15 @@ func TestRoundTripCacheUsesIfModifiedSinceAndFallsBackToCacheOn304(t *testing.T)
 	if err != nil {
 		t.Fatalf("parse downstream URL: %v", err)
 	}
-	cachePath := rt.cachePathForURL(downstreamURL)
-	if err := writeCacheFile(cachePath, []byte("cached-value")); err != nil {
-		t.Fatalf("write cache file: %v", err)
-	}
-
+	cacheKey := defaultCacheKey(rt, downstreamURL)
+	rec := &cacheRecord{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: []byte("cached-value")}
 	modTime := time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)
-	if err := os.Chtimes(cachePath, modTime, modTime); err != nil {
-		t.Fatalf("set cache mtime: %v", err)
+	data, err := encodeRecord(rec)
+	if err != nil {
+		t.Fatalf("encode record: %v", err)
+	}
+	if err := rt.storage.Put(cacheKey, Entry{Data: data, StoredAt: modTime}); err != nil {
+		t.Fatalf("write cache file: %v", err)
 	}
 
 	mockRT.roundTripFunc = func(req *http.Request) (*http.Response, error) {
@@ -169,8 +173,8 @@ func TestRoundTripCacheFallsBackToCacheOnFailure(t *testing.T) {
 	if err != nil {
 		t.Fatalf("parse downstream URL: %v", err)
 	}
-	cachePath := rt.cachePathForURL(downstreamURL)
-	if err := writeCacheFile(cachePath, []byte("cached-error-fallback")); err != nil {
+	rec := &cacheRecord{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: []byte("cached-error-fallback")}
+	if err := writeCacheFile(rt.storage, defaultCacheKey(rt, downstreamURL), rec, false); err != nil {
 		t.Fatalf("write cache file: %v", err)
 	}
 
@@ -203,8 +207,8 @@ func TestRoundTripCachezUsesCacheOnly(t *testing.T) {
 	if err != nil {
 		t.Fatalf("parse downstream URL: %v", err)
 	}
-	cachePath := rt.cachePathForURL(downstreamURL)
-	if err := writeCacheFile(cachePath, []byte("cachez-value")); err != nil {
+	rec := &cacheRecord{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: []byte("cachez-value")}
+	if err := writeCacheFile(rt.storage, defaultCacheKey(rt, downstreamURL), rec, false); err != nil {
 		t.Fatalf("write cache file: %v", err)
 	}
 
@@ -212,8 +216,8 @@ func TestRoundTripCachezUsesCacheOnly(t *testing.T) {
 	if err != nil {
 		t.Fatalf("RoundTrip() error = %v", err)
 	}
-	if mockRT.callCount != 0 {
-		t.Fatalf("expected upstream not to be called, got %d calls", mockRT.callCount)
+	if mockRT.callCount.Load() != 0 {
+		t.Fatalf("expected upstream not to be called, got %d calls", mockRT.callCount.Load())
 	}
 
 	body := readBody(t, resp.Body)
@@ -312,8 +316,8 @@ func TestRoundTripPassThroughWithoutPrefix(t *testing.T) {
 	if err != nil {
 		t.Fatalf("RoundTrip() error = %v", err)
 	}
-	if mockRT.callCount != 1 {
-		t.Fatalf("expected original transport to be called once, got %d", mockRT.callCount)
+	if mockRT.callCount.Load() != 1 {
+		t.Fatalf("expected original transport to be called once, got %d", mockRT.callCount.Load())
 	}
 	if got := readBody(t, resp.Body); got != "pass-through" {
 		t.Fatalf("expected pass-through body, got %q", got)
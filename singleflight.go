@@ -0,0 +1,75 @@
+package httpcache
+
+import (
+	"net/http"
+	"sync"
+)
+
+// flightResult is the fully-resolved outcome of a coalesced cache fetch:
+// enough for every waiting caller to build its own independent
+// *http.Response from it.
+type flightResult struct {
+	statusCode int
+	status     string
+	header     http.Header
+	body       []byte
+	err        error
+}
+
+// flightGroup coalesces concurrent cache fetches that share a key so only
+// one goroutine performs the upstream RoundTrip and cache write; the rest
+// wait for it and reuse its result. It mirrors the shape of
+// golang.org/x/sync/singleflight closely enough for this package's needs,
+// without adding the dependency.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done   chan struct{}
+	result flightResult
+}
+
+// do runs fn for the first caller using key. Concurrent callers using the
+// same key block until that call completes and then share its result,
+// whether it succeeded or failed.
+func (g *flightGroup) do(key string, fn func() flightResult) flightResult {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.result
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.result = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+
+	return call.result
+}
+
+// flightSelectorHash computes the coalescing key component for a request.
+// Once a URL's variant index exists, requests are coalesced by the Vary
+// header names it records, same as lookupVariant/storeVariant. Before that
+// (the index hasn't been saved yet, so the URL's Vary behavior, if any, is
+// still unknown) it falls back to hashing every request header: two cold
+// requests that might turn out to want different variants must not share a
+// flight key, or the loser would silently receive the winner's response.
+func flightSelectorHash(storage Storage, urlKey string, reqHeader http.Header) string {
+	idx, err := loadVariantIndex(storage, urlKey)
+	if err != nil {
+		return fullHeaderHash(reqHeader)
+	}
+	return variantHash(idx.VaryNames, reqHeader)
+}
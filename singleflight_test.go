@@ -0,0 +1,191 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errUpstreamUnavailable = errors.New("upstream unavailable")
+
+func TestRoundTripCoalescesConcurrentRevalidations(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	var upstreamCalls atomic.Int32
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			upstreamCalls.Add(1)
+			entered <- struct{}{}
+			<-release
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("shared")),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	errs := make([]error, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/shared", nil)
+			resp, err := rawRT.RoundTrip(req)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer resp.Body.Close()
+			data, readErr := io.ReadAll(resp.Body)
+			if readErr != nil {
+				errs[i] = readErr
+				return
+			}
+			bodies[i] = string(data)
+		}(i)
+	}
+
+	<-entered
+	time.Sleep(50 * time.Millisecond) // let the other goroutines queue up behind the in-flight call
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine %d: RoundTrip() error = %v", i, err)
+		}
+		if bodies[i] != "shared" {
+			t.Fatalf("goroutine %d: expected shared body, got %q", i, bodies[i])
+		}
+	}
+	if calls := upstreamCalls.Load(); calls != 1 {
+		t.Fatalf("expected exactly 1 upstream call, got %d", calls)
+	}
+}
+
+// TestRoundTripDoesNotCoalesceDifferentColdStartVariants guards against
+// coalescing two concurrent cold-start requests for a URL whose variant
+// index doesn't exist yet: before any response is cached, the Vary names
+// that will turn out to apply are unknown, so the flight key must not
+// collapse to the same value for requests carrying different headers.
+func TestRoundTripDoesNotCoalesceDifferentColdStartVariants(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			entered <- struct{}{}
+			<-release
+			body := "identity-body"
+			if req.Header.Get("Accept-Encoding") == "gzip" {
+				body = "gzip-body"
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Vary": []string{"Accept-Encoding"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	encodings := []string{"identity", "gzip"}
+	bodies := make([]string, len(encodings))
+	errs := make([]error, len(encodings))
+
+	var wg sync.WaitGroup
+	for i, enc := range encodings {
+		wg.Add(1)
+		go func(i int, enc string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/encoded", nil)
+			req.Header.Set("Accept-Encoding", enc)
+			resp, err := rawRT.RoundTrip(req)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer resp.Body.Close()
+			data, readErr := io.ReadAll(resp.Body)
+			if readErr != nil {
+				errs[i] = readErr
+				return
+			}
+			bodies[i] = string(data)
+		}(i, enc)
+	}
+
+	for range encodings {
+		<-entered
+	}
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine %d (%s): RoundTrip() error = %v", i, encodings[i], err)
+		}
+	}
+	if bodies[0] != "identity-body" {
+		t.Fatalf("expected identity request to get identity-body, got %q", bodies[0])
+	}
+	if bodies[1] != "gzip-body" {
+		t.Fatalf("expected gzip request to get gzip-body, got %q", bodies[1])
+	}
+}
+
+func TestRoundTripCoalescingReleasesOnUpstreamError(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	callCount := 0
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			callCount++
+			return nil, errUpstreamUnavailable
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/flaky", nil)
+	if _, err := rawRT.RoundTrip(req); err == nil {
+		t.Fatal("expected error when upstream fails with no cache to fall back to")
+	}
+
+	// The in-flight entry must have been released; a second call should hit
+	// the upstream again rather than hang or replay the first failure forever.
+	req2 := httptest.NewRequest(http.MethodGet, "cache:https://example.com/flaky", nil)
+	if _, err := rawRT.RoundTrip(req2); err == nil {
+		t.Fatal("expected second call to also surface the upstream error")
+	}
+	if callCount != 2 {
+		t.Fatalf("expected upstream to be called twice across the two sequential requests, got %d", callCount)
+	}
+}
@@ -0,0 +1,40 @@
+package httpcache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a Storage implementation's Get when key has
+// no entry.
+var ErrNotFound = errors.New("httpcache: entry not found")
+
+// Entry is a single stored cache blob plus the bookkeeping the cache needs
+// to revalidate and evict it.
+type Entry struct {
+	Data []byte
+	// StoredAt is when the entry was written. Storage implementations that
+	// can derive this themselves (e.g. from a file's mtime) may ignore a
+	// caller-supplied value on Put and report their own on Get.
+	StoredAt time.Time
+}
+
+// Storage is the backend a cacheRoundTripper persists cache records and
+// variant indexes through. Keys are strings chosen by the cache; a backend
+// is free to map them onto whatever it likes (a filesystem backend maps '/'
+// to its path separator). Beyond recognizing variant index keys (see
+// isVariantIndexKey) to exempt them from eviction, the built-in backends
+// attach no meaning to a key's structure.
+type Storage interface {
+	Get(key string) (Entry, error)
+	Put(key string, e Entry) error
+	Delete(key string) error
+	// Keys returns every key currently stored, for garbage collection.
+	// Per-URL variant index keys are excluded from both backends'
+	// size/entry-bounded eviction (see isVariantIndexKey): one logical
+	// cached response is an index key plus a variant entry key, and an
+	// index evicted on its own would leave its variant entries unreachable.
+	// A variant entry can still be evicted while its index remains; a
+	// lookup of it then misses.
+	Keys() ([]string, error)
+}
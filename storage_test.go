@@ -0,0 +1,203 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLRUStorageEvictsLeastRecentlyUsed(t *testing.T) {
+	storage := NewLRUStorage(10)
+
+	if err := storage.Put("a", Entry{Data: []byte("12345")}); err != nil {
+		t.Fatalf("Put(a) error = %v", err)
+	}
+	if err := storage.Put("b", Entry{Data: []byte("12345")}); err != nil {
+		t.Fatalf("Put(b) error = %v", err)
+	}
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, err := storage.Get("a"); err != nil {
+		t.Fatalf("Get(a) error = %v", err)
+	}
+
+	if err := storage.Put("c", Entry{Data: []byte("12345")}); err != nil {
+		t.Fatalf("Put(c) error = %v", err)
+	}
+
+	if _, err := storage.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected b to be evicted, got err = %v", err)
+	}
+	if _, err := storage.Get("a"); err != nil {
+		t.Fatalf("expected a to survive eviction, got err = %v", err)
+	}
+	if _, err := storage.Get("c"); err != nil {
+		t.Fatalf("expected c to survive eviction, got err = %v", err)
+	}
+}
+
+func TestLRUStorageDelete(t *testing.T) {
+	storage := NewLRUStorage(0)
+	if err := storage.Put("k", Entry{Data: []byte("v")}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := storage.Delete("k"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := storage.Get("k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestFSStorageEvictsLeastRecentlyAccessed(t *testing.T) {
+	storage, err := NewFSStorageWithOptions(filepath.Join(t.TempDir(), "cache"), FSStorageOptions{MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("NewFSStorageWithOptions() error = %v", err)
+	}
+	defer storage.Close()
+
+	if err := storage.Put("a", Entry{Data: []byte("1"), StoredAt: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatalf("Put(a) error = %v", err)
+	}
+	if err := storage.Put("b", Entry{Data: []byte("1"), StoredAt: time.Now().Add(-1 * time.Hour)}); err != nil {
+		t.Fatalf("Put(b) error = %v", err)
+	}
+	if err := storage.Put("c", Entry{Data: []byte("1"), StoredAt: time.Now()}); err != nil {
+		t.Fatalf("Put(c) error = %v", err)
+	}
+
+	storage.evict()
+
+	if _, err := storage.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected oldest entry a to be evicted, got err = %v", err)
+	}
+	if _, err := storage.Get("b"); err != nil {
+		t.Fatalf("expected b to survive eviction, got err = %v", err)
+	}
+	if _, err := storage.Get("c"); err != nil {
+		t.Fatalf("expected c to survive eviction, got err = %v", err)
+	}
+}
+
+// TestFSStorageEvictionDoesNotOrphanVariantIndex guards against the variant
+// index counting toward MaxEntries: one logical cached response costs two
+// keys (the index and a variant entry), and a per-entry budget of one must
+// not evict either half of that pair.
+func TestFSStorageEvictionDoesNotOrphanVariantIndex(t *testing.T) {
+	storage, err := NewFSStorageWithOptions(filepath.Join(t.TempDir(), "cache"), FSStorageOptions{MaxEntries: 1})
+	if err != nil {
+		t.Fatalf("NewFSStorageWithOptions() error = %v", err)
+	}
+	defer storage.Close()
+
+	const urlKey = "example"
+	idx := &variantIndex{VaryNames: []string{"Accept-Encoding"}, Variants: map[string]string{"h": "variant-h"}}
+	if err := saveVariantIndex(storage, urlKey, idx); err != nil {
+		t.Fatalf("saveVariantIndex() error = %v", err)
+	}
+	rec := &cacheRecord{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: []byte("variant body")}
+	if err := writeCacheFile(storage, variantEntryKey(urlKey, "variant-h"), rec, false); err != nil {
+		t.Fatalf("writeCacheFile() error = %v", err)
+	}
+
+	storage.evict()
+
+	if _, err := storage.Get(variantEntryKey(urlKey, "variant-h")); err != nil {
+		t.Fatalf("expected variant entry to survive eviction of a 2-key response under MaxEntries=1, got err = %v", err)
+	}
+	if _, err := loadVariantIndex(storage, urlKey); err != nil {
+		t.Fatalf("expected variant index to survive, got err = %v", err)
+	}
+}
+
+// TestLRUStoragePinsVariantIndexBytes guards against the same desync in
+// LRUStorage: a variant index must not count against maxBytes or be chosen
+// as an LRU eviction victim, since it shouldn't be deleted independently
+// of the variant entries it describes.
+func TestLRUStoragePinsVariantIndexBytes(t *testing.T) {
+	storage := NewLRUStorage(1 << 20)
+
+	const urlKey = "example"
+	idx := &variantIndex{VaryNames: []string{"Accept-Encoding"}, Variants: map[string]string{"h": "variant-h"}}
+	if err := saveVariantIndex(storage, urlKey, idx); err != nil {
+		t.Fatalf("saveVariantIndex() error = %v", err)
+	}
+	if storage.usedBytes != 0 {
+		t.Fatalf("expected variant index bytes to be excluded from the LRU budget, got usedBytes = %d", storage.usedBytes)
+	}
+
+	rec := &cacheRecord{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: []byte("variant body")}
+	if err := writeCacheFile(storage, variantEntryKey(urlKey, "variant-h"), rec, false); err != nil {
+		t.Fatalf("writeCacheFile() error = %v", err)
+	}
+	if storage.usedBytes == 0 {
+		t.Fatal("expected variant entry bytes to count toward the LRU budget")
+	}
+
+	// A budget that fits the variant entry alone but not the pair must not
+	// evict the pinned index.
+	tight := NewLRUStorage(storage.usedBytes)
+	if err := saveVariantIndex(tight, urlKey, idx); err != nil {
+		t.Fatalf("saveVariantIndex() error = %v", err)
+	}
+	if err := writeCacheFile(tight, variantEntryKey(urlKey, "variant-h"), rec, false); err != nil {
+		t.Fatalf("writeCacheFile() error = %v", err)
+	}
+
+	if _, err := tight.Get(variantEntryKey(urlKey, "variant-h")); err != nil {
+		t.Fatalf("expected variant entry to survive, got err = %v", err)
+	}
+	if _, err := loadVariantIndex(tight, urlKey); err != nil {
+		t.Fatalf("expected variant index to survive, got err = %v", err)
+	}
+}
+
+func TestRoundTripWithLRUStorage(t *testing.T) {
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("in-memory")),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripperWithStorage(NewLRUStorage(1<<20), mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripperWithStorage() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	resp, err := rawRT.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, resp.Body); got != "in-memory" {
+		t.Fatalf("expected in-memory body, got %q", got)
+	}
+
+	mockRT.roundTripFunc = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusNotModified, Body: http.NoBody, Request: req}, nil
+	}
+
+	req2 := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	resp2, err := rawRT.RoundTrip(req2)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, resp2.Body); got != "in-memory" {
+		t.Fatalf("expected cached in-memory body, got %q", got)
+	}
+}
+
+func TestNewRoundTripperWithStorageRejectsNilStorage(t *testing.T) {
+	if _, err := NewRoundTripperWithStorage(nil, nil); err == nil {
+		t.Fatal("expected error for nil storage")
+	}
+}
@@ -0,0 +1,223 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultVariantFile is the cache entry used for a URL that has never
+// produced a Vary response, or whose variant index has no entries yet.
+const defaultVariantFile = "default"
+
+// variantIndexFile is the name of the per-URL index listing the Vary header
+// names observed for that URL and the cache entry that holds each variant.
+const variantIndexFile = "variants"
+
+// variantIndex records, for a single URL, which request headers its
+// responses vary on and which cache entry stores each observed combination
+// of values for those headers.
+type variantIndex struct {
+	// VaryNames is the union of header names ever seen in a Vary response
+	// header for this URL, in first-observed order. A Vary: * response
+	// replaces it with just "*".
+	VaryNames []string
+	// Variants maps a hash of the selected request header values to the
+	// cache entry name (relative to the URL's key) holding that variant.
+	Variants map[string]string
+}
+
+func variantIndexKey(urlKey string) string {
+	return urlKey + "/" + variantIndexFile
+}
+
+func variantEntryKey(urlKey, name string) string {
+	return urlKey + "/" + name
+}
+
+// isVariantIndexKey reports whether key names a per-URL variant index
+// rather than a cached response body. Storage eviction excludes these from
+// its size/entry budget and never deletes them directly: the index is tiny
+// next to the bodies it points to, and evicting it independently of the
+// variant entries it describes would desynchronize the two, leaving a
+// variant entry that can never be looked up again. Variant entries are
+// still evicted on their own; the index then points at a missing entry and
+// a lookup through it reads as a cache miss. See Storage.Keys.
+func isVariantIndexKey(key string) bool {
+	return strings.HasSuffix(key, "/"+variantIndexFile)
+}
+
+// urlIndexLocks serializes storeVariant's load-modify-save sequence per
+// urlKey, so concurrent responses for different variants of the same URL
+// (which flightGroup does not coalesce, since they legitimately have
+// different flight keys) don't race on the shared variant index and
+// silently drop each other's variant mapping.
+type urlIndexLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock acquires the per-urlKey mutex and returns a function that releases
+// it.
+func (l *urlIndexLocks) lock(urlKey string) func() {
+	l.mu.Lock()
+	keyLock, ok := l.locks[urlKey]
+	if !ok {
+		if l.locks == nil {
+			l.locks = make(map[string]*sync.Mutex)
+		}
+		keyLock = &sync.Mutex{}
+		l.locks[urlKey] = keyLock
+	}
+	l.mu.Unlock()
+
+	keyLock.Lock()
+	return keyLock.Unlock
+}
+
+func loadVariantIndex(storage Storage, urlKey string) (*variantIndex, error) {
+	entry, err := storage.Get(variantIndexKey(urlKey))
+	if err != nil {
+		return nil, err
+	}
+	var idx variantIndex
+	if err := gob.NewDecoder(bytes.NewReader(entry.Data)).Decode(&idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+func saveVariantIndex(storage Storage, urlKey string, idx *variantIndex) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+	return storage.Put(variantIndexKey(urlKey), Entry{Data: buf.Bytes()})
+}
+
+// parseVaryNames splits a Vary header value into canonical header names.
+func parseVaryNames(vary string) []string {
+	if vary == "" {
+		return nil
+	}
+	var names []string
+	for _, part := range strings.Split(vary, ",") {
+		name := strings.TrimSpace(part)
+		if name == "" {
+			continue
+		}
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	return names
+}
+
+func containsVaryStar(names []string) bool {
+	for _, name := range names {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeVaryNames appends any names not already present in existing,
+// preserving the existing order.
+func mergeVaryNames(existing []string, names []string) []string {
+	seen := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		seen[name] = true
+	}
+	for _, name := range names {
+		if !seen[name] {
+			existing = append(existing, name)
+			seen[name] = true
+		}
+	}
+	return existing
+}
+
+// variantHash computes a stable key for the values of the given header
+// names as present on header. An empty names list always hashes to the
+// same key, which backs the "no Vary observed" default variant.
+func variantHash(names []string, header http.Header) string {
+	hasher := sha256.New()
+	for _, name := range names {
+		hasher.Write([]byte(name))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(header.Get(name)))
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// fullHeaderHash hashes every header name present on header, sorted for
+// determinism. It is used in place of variantHash when a URL's Vary
+// behavior isn't known yet (no variant index has been saved), so that
+// single-flight coalescing doesn't merge two requests that turn out to
+// want different variants just because neither one's Vary names were
+// known at request time.
+func fullHeaderHash(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return variantHash(names, header)
+}
+
+// lookupVariant resolves the Storage key that should serve reqHeader for
+// urlKey. When the index is missing or has no entries it returns the
+// default ("no Vary") entry's key with ok=true, whether or not that entry
+// exists; it returns ok=false when the index records Vary: * or has no
+// variant matching reqHeader.
+func lookupVariant(storage Storage, urlKey string, reqHeader http.Header) (key string, ok bool) {
+	idx, err := loadVariantIndex(storage, urlKey)
+	if err != nil || len(idx.Variants) == 0 {
+		return variantEntryKey(urlKey, defaultVariantFile), true
+	}
+	if containsVaryStar(idx.VaryNames) {
+		return "", false
+	}
+
+	hash := variantHash(idx.VaryNames, reqHeader)
+	name, found := idx.Variants[hash]
+	if !found {
+		return "", false
+	}
+	return variantEntryKey(urlKey, name), true
+}
+
+// storeVariant records the cache entry for a response produced by a
+// request with reqHeader, updating urlKey's variant index from
+// respHeader's Vary header, and returns the key the record was (or should
+// be) written to.
+func storeVariant(storage Storage, urlKey string, reqHeader, respHeader http.Header) (key string, idx *variantIndex, err error) {
+	idx, err = loadVariantIndex(storage, urlKey)
+	if err != nil {
+		idx = &variantIndex{Variants: map[string]string{}}
+	}
+	if idx.Variants == nil {
+		idx.Variants = map[string]string{}
+	}
+
+	varyNames := parseVaryNames(respHeader.Get("Vary"))
+	if containsVaryStar(varyNames) {
+		idx.VaryNames = []string{"*"}
+		idx.Variants["*"] = defaultVariantFile
+		return variantEntryKey(urlKey, defaultVariantFile), idx, nil
+	}
+
+	idx.VaryNames = mergeVaryNames(idx.VaryNames, varyNames)
+	hash := variantHash(idx.VaryNames, reqHeader)
+	name := defaultVariantFile
+	if len(idx.VaryNames) > 0 {
+		name = "variant-" + hash[:16]
+	}
+	idx.Variants[hash] = name
+	return variantEntryKey(urlKey, name), idx, nil
+}
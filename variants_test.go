@@ -0,0 +1,222 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRoundTripVaryKeepsVariantsSeparate(t *testing.T) {
+	cacheDir := t.TempDir()
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			body := "plain"
+			if req.Header.Get("Accept-Encoding") == "gzip" {
+				body = "gzipped"
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Vary": []string{"Accept-Encoding"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	plainReq := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	plainResp, err := rawRT.RoundTrip(plainReq)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, plainResp.Body); got != "plain" {
+		t.Fatalf("expected plain body, got %q", got)
+	}
+
+	gzipReq := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	gzipReq.Header.Set("Accept-Encoding", "gzip")
+	gzipResp, err := rawRT.RoundTrip(gzipReq)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, gzipResp.Body); got != "gzipped" {
+		t.Fatalf("expected gzipped body, got %q", got)
+	}
+
+	// Both variants must now be servable from cache without hitting upstream again.
+	mockRT.roundTripFunc = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusNotModified, Body: http.NoBody, Request: req}, nil
+	}
+
+	plainReq2 := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	plainResp2, err := rawRT.RoundTrip(plainReq2)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, plainResp2.Body); got != "plain" {
+		t.Fatalf("expected cached plain body, got %q", got)
+	}
+
+	gzipReq2 := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	gzipReq2.Header.Set("Accept-Encoding", "gzip")
+	gzipResp2, err := rawRT.RoundTrip(gzipReq2)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, gzipResp2.Body); got != "gzipped" {
+		t.Fatalf("expected cached gzipped body, got %q", got)
+	}
+}
+
+func TestRoundTripVaryStarAlwaysMisses(t *testing.T) {
+	cacheDir := t.TempDir()
+	calls := 0
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Vary": []string{"*"}},
+				Body:       io.NopCloser(strings.NewReader("body")),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+		if _, err := rawRT.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip() error = %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected upstream to be called on every request with Vary: *, got %d calls", calls)
+	}
+}
+
+// TestRoundTripStoresConcurrentVariantsWithoutLosingEntries guards against
+// a lost update in storeVariant's load-modify-save sequence: concurrent
+// responses for distinct variants of the same URL have distinct flight
+// keys, so flightGroup does not coalesce them, and each must still land in
+// the shared per-URL variant index rather than clobbering the others.
+func TestRoundTripStoresConcurrentVariantsWithoutLosingEntries(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	const n = 25
+	ready := make(chan struct{}, n)
+	release := make(chan struct{})
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			ready <- struct{}{}
+			<-release
+			lang := req.Header.Get("Accept-Language")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Vary": []string{"Accept-Language"}},
+				Body:       io.NopCloser(strings.NewReader("body-" + lang)),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+			req.Header.Set("Accept-Language", "v"+strconv.Itoa(i))
+			if _, err := rawRT.RoundTrip(req); err != nil {
+				errs[i] = err
+			}
+		}(i)
+	}
+
+	for i := 0; i < n; i++ {
+		<-ready
+	}
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine %d: RoundTrip() error = %v", i, err)
+		}
+	}
+
+	// Every variant must now be independently retrievable from cache
+	// without falling back to upstream.
+	for i := 0; i < n; i++ {
+		req := httptest.NewRequest(http.MethodGet, "cachez:https://example.com/data", nil)
+		req.Header.Set("Accept-Language", "v"+strconv.Itoa(i))
+		resp, err := rawRT.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("variant v%d: cachez RoundTrip() error = %v", i, err)
+		}
+		want := "body-v" + strconv.Itoa(i)
+		if got := readBody(t, resp.Body); got != want {
+			t.Fatalf("variant v%d: expected %q, got %q", i, want, got)
+		}
+	}
+}
+
+func TestRoundTripCachezRespectsVariants(t *testing.T) {
+	cacheDir := t.TempDir()
+	mockRT := &mockRoundTripper{
+		roundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Vary": []string{"Accept-Language"}},
+				Body:       io.NopCloser(strings.NewReader("en-body")),
+				Request:    req,
+			}, nil
+		},
+	}
+
+	rawRT, err := NewRoundTripper(cacheDir, mockRT)
+	if err != nil {
+		t.Fatalf("NewRoundTripper() error = %v", err)
+	}
+
+	seedReq := httptest.NewRequest(http.MethodGet, "cache:https://example.com/data", nil)
+	seedReq.Header.Set("Accept-Language", "en")
+	if _, err := rawRT.RoundTrip(seedReq); err != nil {
+		t.Fatalf("seed RoundTrip() error = %v", err)
+	}
+
+	hitReq := httptest.NewRequest(http.MethodGet, "cachez:https://example.com/data", nil)
+	hitReq.Header.Set("Accept-Language", "en")
+	resp, err := rawRT.RoundTrip(hitReq)
+	if err != nil {
+		t.Fatalf("cachez RoundTrip() error = %v", err)
+	}
+	if got := readBody(t, resp.Body); got != "en-body" {
+		t.Fatalf("expected en-body, got %q", got)
+	}
+
+	missReq := httptest.NewRequest(http.MethodGet, "cachez:https://example.com/data", nil)
+	missReq.Header.Set("Accept-Language", "fr")
+	if _, err := rawRT.RoundTrip(missReq); err == nil {
+		t.Fatal("expected cache miss for an unseen variant")
+	}
+}